@@ -47,16 +47,70 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"hash/crc32"
+	"index/suffixarray"
 	"io"
 	"math/rand"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// binaryMagic and binaryFormatVersion identify WriteBinaryModel's output so
+// generate can auto-detect it and reject files from an incompatible
+// version, instead of misparsing them the way the whitespace-delimited
+// text format does on tokens containing whitespace or digits.
+const (
+	binaryMagic         = "MARKMDL\x00"
+	binaryFormatVersion = uint16(1)
+)
+
+// beginToken and endToken bound a sentence in sentence mode: they're
+// injected as ordinary tokens around each sentence so GenerateSentence can
+// always start from a well-defined prefix and knows when to stop.
+const (
+	beginToken = "_BEGIN_"
+	endToken   = "_END_"
+)
+
+// SentenceSplitter breaks a block of text into sentences. Implementations
+// decide what counts as a sentence boundary; the default splitter used by
+// EnableSentenceMode splits on runs of '.', '!' and '?'.
+type SentenceSplitter interface {
+	Split(text string) []string
+}
+
+// regexSentenceSplitter is the default SentenceSplitter: it splits on runs
+// of sentence-ending punctuation and drops empty/whitespace-only pieces.
+type regexSentenceSplitter struct {
+	re *regexp.Regexp
+}
+
+func newRegexSentenceSplitter() *regexSentenceSplitter {
+	return &regexSentenceSplitter{re: regexp.MustCompile(`[.!?]+`)}
+}
+
+func (s *regexSentenceSplitter) Split(text string) []string {
+	raw := s.re.Split(text, -1)
+	sentences := make([]string, 0, len(raw))
+	for _, r := range raw {
+		if strings.TrimSpace(r) != "" {
+			sentences = append(sentences, r)
+		}
+	}
+	return sentences
+}
+
 // Prefix is a Markov chain prefix of one or more words.
 type Prefix []string
 
@@ -71,63 +125,272 @@ func (p Prefix) Shift(word string) {
 	p[len(p)-1] = word
 }
 
-// Chain contains a map ("chain") of prefixes to a list of suffixes.
-// A prefix is a string of prefixLen words joined with spaces.
+// Chain contains a map ("pairmap") of prefixes to their suffixes and
+// frequencies. A prefix is a string of prefixLen words joined with spaces.
 // A suffix is a single word. A prefix can have multiple suffixes.
+// Build, BuildReaders, Generate and GenerateSentence touch pairmap,
+// prefixStorage and aliasTables only while holding mu, since BuildReaders
+// ingests multiple sources concurrently. rng, sentenceMode and splitter are
+// not guarded by mu, and runInteractive reads pairmap without it.
 type Chain struct {
-	chain         map[string][]string       // used in READ, holds a prefix and suffix
+	mu            sync.RWMutex
 	prefixLen     int                       // order of the markov chain
-	text          [][]string                // used as a helper to make modelfile formatted in lexicographical order
 	pairmap       map[string]map[string]int // used in GENERATE, takes in text from modelfile and generates output from this data structure
-	prefixStorage []string                  // used as a helper to check if the current prefix has already been used
+	prefixStorage []string                  // prefixes seen so far, in first-seen order; Generate picks a random start from these
+	rng           *rand.Rand                // source of randomness for Generate, seeded independently of the package-global rand
+	aliasTables   map[string]*aliasTable    // lazily built per-prefix samplers, keyed by prefix string
+	sentenceMode  bool                      // if true, Build segments input into sentences bounded by beginToken/endToken
+	splitter      SentenceSplitter          // used to find sentence boundaries when sentenceMode is on
 }
 
 // NewChain returns a new Chain with prefixes of prefixLen words.
+// The Chain's PRNG is seeded from the current time; call SetSeed for
+// deterministic output.
 func NewChain(prefixLen int) *Chain {
-	return &Chain{make(map[string][]string), prefixLen, make([][]string, 0), make(map[string]map[string]int), make([]string, 0)}
+	return &Chain{
+		prefixLen:     prefixLen,
+		pairmap:       make(map[string]map[string]int),
+		prefixStorage: make([]string, 0),
+		rng:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetSeed reseeds the Chain's PRNG, making Generate's output deterministic
+// for a given model. Useful for tests and for callers that want
+// reproducible runs.
+func (c *Chain) SetSeed(seed int64) {
+	c.rng = rand.New(rand.NewSource(seed))
+}
+
+// EnableSentenceMode turns on sentence segmentation for future Build calls.
+// Each sentence found by splitter is padded with prefixLen copies of
+// beginToken and trailed with endToken before being folded into the chain,
+// so GenerateSentence can always start from a _BEGIN_-anchored prefix and
+// stop cleanly when _END_ is drawn. A nil splitter uses the default
+// regex-based splitter on '.', '!' and '?'.
+func (c *Chain) EnableSentenceMode(splitter SentenceSplitter) {
+	c.sentenceMode = true
+	if splitter == nil {
+		splitter = newRegexSentenceSplitter()
+	}
+	c.splitter = splitter
+}
+
+// aliasTable is a Vose's-alias-method sampler over the suffixes of a single
+// prefix, letting Generate draw a weighted-random suffix in O(1) instead of
+// expanding every occurrence into a flat slice.
+type aliasTable struct {
+	suffixes []string  // suffix strings, indexed by column
+	prob     []float64 // prob[i]: chance column i keeps its own suffix
+	alias    []int     // alias[i]: suffix to use when column i fails its prob check
 }
 
-// helper function: checks if a string is in a list of strings
-func Find(slice []string, val string) bool {
-	for _, item := range slice {
-		if item == val {
-			return true
+// newAliasTable builds an aliasTable from a suffix -> frequency map.
+func newAliasTable(choices map[string]int) *aliasTable {
+	suffixes := make([]string, 0, len(choices))
+	for s := range choices {
+		suffixes = append(suffixes, s)
+	}
+	sort.Strings(suffixes) // deterministic column order for a given seed
+
+	k := len(suffixes)
+	total := 0
+	for _, s := range suffixes {
+		total += choices[s]
+	}
+
+	scaled := make([]float64, k)
+	for i, s := range suffixes {
+		scaled[i] = float64(choices[s]) * float64(k) / float64(total)
+	}
+
+	prob := make([]float64, k)
+	alias := make([]int, k)
+
+	small := make([]int, 0, k)
+	large := make([]int, 0, k)
+	for i, p := range scaled {
+		if p < 1 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
+		}
+	}
+
+	for len(small) > 0 && len(large) > 0 {
+		s := small[len(small)-1]
+		small = small[:len(small)-1]
+		l := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		prob[s] = scaled[s]
+		alias[s] = l
+
+		scaled[l] -= 1 - scaled[s]
+		if scaled[l] < 1 {
+			small = append(small, l)
+		} else {
+			large = append(large, l)
 		}
 	}
-	return false
+
+	// leftover columns have a scaled value of exactly 1 (e.g. a single
+	// suffix, or equal frequencies) or within floating-point rounding of
+	// it; they always keep their own suffix.
+	for len(large) > 0 {
+		l := large[len(large)-1]
+		large = large[:len(large)-1]
+		prob[l] = 1
+	}
+	for len(small) > 0 {
+		s := small[len(small)-1]
+		small = small[:len(small)-1]
+		prob[s] = 1
+	}
+
+	return &aliasTable{suffixes: suffixes, prob: prob, alias: alias}
+}
+
+// sample draws one suffix, weighted by the frequencies it was built from.
+func (t *aliasTable) sample(rng *rand.Rand) string {
+	i := rng.Intn(len(t.suffixes))
+	if rng.Float64() < t.prob[i] {
+		return t.suffixes[i]
+	}
+	return t.suffixes[t.alias[i]]
 }
 
 // Build reads text from the provided Reader and
 // parses it into prefixes and suffixes that are stored in Chain.
 func (c *Chain) Build(r io.Reader) {
+	if c.sentenceMode {
+		c.buildSentences(r)
+		return
+	}
+
 	br := bufio.NewReader(r)
-	p := make(Prefix, c.prefixLen) //make a list of strings w/ length prefixLen
-	currtext := make([]string, 0)
+	words := make([]string, 0)
 	for {
 		var s string
 		if _, err := fmt.Fscan(br, &s); err != nil {
 			break
 		}
+		words = append(words, s)
+	}
+	c.ingest(words)
+}
 
-		currtext = append(currtext, s)
+// buildSentences segments r into sentences with c.splitter, wraps each in
+// beginToken/endToken padding, and folds the result into the chain one
+// sentence at a time.
+func (c *Chain) buildSentences(r io.Reader) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		panic("error reading input for sentence mode")
+	}
 
-		key := p.String() //joins elements of p together, separates with " "
+	for _, sentence := range c.splitter.Split(string(data)) {
+		words := strings.Fields(sentence)
+		if len(words) == 0 {
+			continue
+		}
 
-		if c.pairmap[key] == nil { // if there is not already an instance of c.[key], make one
-			c.pairmap[key] = make(map[string]int)
+		padded := make([]string, 0, c.prefixLen+len(words)+1)
+		for i := 0; i < c.prefixLen; i++ {
+			padded = append(padded, beginToken)
 		}
-		c.pairmap[key][s] += 1
+		padded = append(padded, words...)
+		padded = append(padded, endToken)
 
-		if !Find(c.chain[key], s) { //if the current string is not already in the set of choices, add it
-			c.chain[key] = append(c.chain[key], s)
+		c.ingest(padded)
+	}
+}
+
+// ingest folds a single pre-tokenized block of words into the chain,
+// shifting a prefix window across it. It holds mu for its whole run, so
+// concurrent Build/BuildReaders callers never see a half-updated prefix.
+func (c *Chain) ingest(words []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := make(Prefix, c.prefixLen)
+	for _, s := range words {
+		key := p.String()
+		if c.pairmap[key] == nil {
+			c.pairmap[key] = make(map[string]int)
+			c.prefixStorage = append(c.prefixStorage, key) // first time we've seen this prefix: it's a valid Generate start point
 		}
+		c.pairmap[key][s] += 1
 
-		//fmt.Println(c.chain[key])
 		p.Shift(s)
 	}
-	c.text = append(c.text, currtext) //add the current block of text into the list of texts
-	//fmt.Println(c.text) //debug
+	c.aliasTables = nil // pairmap changed, invalidate the cached samplers
+}
+
+// BuildReaders ingests many readers concurrently: it spawns one goroutine
+// per reader, bounded to workers running at a time, each building its own
+// local Chain, then merges every local Chain into c under mu. This avoids
+// the lock contention of mutating a single shared Chain word-by-word and
+// lets Build scale to multi-gigabyte, multi-file corpora.
+func (c *Chain) BuildReaders(ctx context.Context, readers []io.Reader, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	sem := make(chan struct{}, workers)
+	partials := make(chan *Chain, len(readers))
+	var wg sync.WaitGroup
 
+	for _, r := range readers {
+		r := r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			partial := NewChain(c.prefixLen)
+			if c.sentenceMode {
+				partial.EnableSentenceMode(c.splitter)
+			}
+			partial.Build(r)
+			partials <- partial
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(partials)
+	}()
+
+	for partial := range partials {
+		c.merge(partial)
+	}
+
+	return ctx.Err()
+}
+
+// merge folds another Chain's pairmap into c under mu.
+func (c *Chain) merge(other *Chain) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for prefix, suffixes := range other.pairmap {
+		if c.pairmap[prefix] == nil {
+			c.pairmap[prefix] = make(map[string]int)
+			c.prefixStorage = append(c.prefixStorage, prefix) // first time c has seen this prefix: it's a valid Generate start point
+		}
+		for suffix, freq := range suffixes {
+			c.pairmap[prefix][suffix] += freq
+		}
+	}
+
+	c.aliasTables = nil // pairmap changed, invalidate the cached samplers
 }
 
 // WriteModel writes a model frequency file with correct formatting to outFile, specified by user
@@ -140,93 +403,510 @@ func (c *Chain) WriteModel(outFile string) { //use chain variables.
 
 	fmt.Fprintln(f, c.prefixLen) //add order number to first line of outputFile
 
-	for j := range c.text {
-		p := make(Prefix, c.prefixLen)
-		for i := 0; i < len(c.text[j]); i++ {
-			choices := c.chain[p.String()] //returns map of suffixes + freqs
-			if len(choices) == 0 {         // if no more suggested words, break
-				break
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	prefixes := make([]string, 0, len(c.pairmap)) // walk pairmap directly, in sorted order
+	for prefix, choices := range c.pairmap {
+		if len(choices) == 0 {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		choices := c.pairmap[prefix]
+
+		suffixes := make([]string, 0, len(choices))
+		for suffix := range choices {
+			suffixes = append(suffixes, suffix)
+		}
+		sort.Strings(suffixes)
+
+		line := make([]string, 0, c.prefixLen+2*len(suffixes)) //holds the whole row of input: prefix + suffixes + freq
+
+		// format prefix, using strings.Split (not Fields) so empty-string words keep their place
+		for _, word := range strings.Split(prefix, " ") {
+			if word == "" { //format empty spaces
+				line = append(line, strconv.Quote(word))
+			} else {
+				line = append(line, word)
 			}
+		}
 
-			next := c.text[j][i] //next word is chosen, used when shifting the prefix over a word
-
-			line := make([]string, 0) //holds the whole row of input: prefix + suffixes + freq
-
-			if !Find(c.prefixStorage, p.String()) { // if  current prefix has not already been used
-				c.prefixStorage = append(c.prefixStorage, p.String())
-				// format current prefix
-				for _, word := range p {
-					if word == "" { //format empty spaces
-						line = append(line, strconv.Quote(word))
-					} else {
-						line = append(line, word)
-					}
-				} //end prefix for
-
-				//format chosen suffix
-				for _, word := range choices {
-					if word == "" {
-						line = append(line, strconv.Quote(word))
-						//do I need to add freq of "" ?
-					} else {
-						line = append(line, word)
-						line = append(line, strconv.Itoa(c.pairmap[p.String()][word]))
-					}
-				}
-
-				line_string := strings.Join(line, " ") //creates a string from list
-				fmt.Fprintln(f, line_string)           //prints to outputfile
-				p.Shift(next)
+		//format chosen suffixes
+		for _, word := range suffixes {
+			if word == "" {
+				line = append(line, strconv.Quote(word))
+				//do I need to add freq of "" ?
 			} else {
-				p.Shift(next)
-			} //end if
+				line = append(line, word)
+				line = append(line, strconv.Itoa(choices[word]))
+			}
+		}
+
+		fmt.Fprintln(f, strings.Join(line, " ")) //prints to outputfile
+	}
+}
+
+// writeUvarint appends v to buf as a varint, the same encoding used
+// throughout the binary model format for lengths, ids and frequencies.
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+// WriteBinaryModel writes c to outFile in the self-describing binary
+// format: an 8-byte magic, a one-byte gzip flag, then the payload: a
+// uint16 version, a uint8 prefix length, a deduplicated string table, and
+// a section of prefix records (each a [prefixLen]varint string-table
+// index, a varint suffix count, and that many (varint string-table index,
+// varint freq) pairs). The whole payload is optionally gzipped and
+// followed by a CRC32 trailer over the (possibly gzipped) bytes actually
+// written, so a truncated or corrupted file is caught at read time
+// instead of silently misparsed.
+func (c *Chain) WriteBinaryModel(outFile string, useGzip bool) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	vocab := make(map[string]uint64)
+	tokens := make([]string, 0)
+	internToken := func(tok string) uint64 {
+		if id, ok := vocab[tok]; ok {
+			return id
+		}
+		id := uint64(len(tokens))
+		vocab[tok] = id
+		tokens = append(tokens, tok)
+		return id
+	}
+
+	type suffixEntry struct {
+		id   uint64
+		freq int
+	}
+	type prefixRecord struct {
+		ids      []uint64
+		suffixes []suffixEntry
+	}
+
+	prefixes := make([]string, 0, len(c.pairmap))
+	for prefix, choices := range c.pairmap {
+		if len(choices) == 0 {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	records := make([]prefixRecord, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		choices := c.pairmap[prefix]
+
+		words := strings.Split(prefix, " ")
+		ids := make([]uint64, len(words))
+		for i, w := range words {
+			ids[i] = internToken(w)
+		}
+
+		suffixes := make([]string, 0, len(choices))
+		for s := range choices {
+			suffixes = append(suffixes, s)
+		}
+		sort.Strings(suffixes)
+
+		rec := prefixRecord{ids: ids, suffixes: make([]suffixEntry, 0, len(suffixes))}
+		for _, s := range suffixes {
+			rec.suffixes = append(rec.suffixes, suffixEntry{id: internToken(s), freq: choices[s]})
+		}
+		records = append(records, rec)
+	}
+
+	var payload bytes.Buffer
+	binary.Write(&payload, binary.BigEndian, binaryFormatVersion)
+	payload.WriteByte(byte(c.prefixLen))
+
+	writeUvarint(&payload, uint64(len(tokens)))
+	for _, tok := range tokens {
+		writeUvarint(&payload, uint64(len(tok)))
+		payload.WriteString(tok)
+	}
+
+	writeUvarint(&payload, uint64(len(records)))
+	for _, rec := range records {
+		for _, id := range rec.ids {
+			writeUvarint(&payload, id)
+		}
+		writeUvarint(&payload, uint64(len(rec.suffixes)))
+		for _, sfx := range rec.suffixes {
+			writeUvarint(&payload, sfx.id)
+			writeUvarint(&payload, uint64(sfx.freq))
+		}
+	}
 
-		} //end for
+	body := payload.Bytes()
+	gzipFlag := byte(0)
+	if useGzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(payload.Bytes()); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		body = compressed.Bytes()
+		gzipFlag = 1
+	}
 
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
 	}
-	//fmt.Println("text length is ", c.text)
-	//prefixStorage := make([]string, 0)
+	defer f.Close()
 
+	if _, err := f.WriteString(binaryMagic); err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte{gzipFlag}); err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		return err
+	}
+
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(body))
+	_, err = f.Write(trailer[:])
+	return err
+}
+
+// isBinaryModel reports whether modelfile starts with the binary model
+// magic, so generate can pick the right reader without a --format flag.
+func isBinaryModel(modelfile string) (bool, error) {
+	f, err := os.Open(modelfile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(magic) == binaryMagic, nil
+}
+
+// ReadBinaryChainFromFile reads a model written by WriteBinaryModel,
+// verifying its magic, version and CRC32 trailer before trusting the
+// payload.
+func ReadBinaryChainFromFile(modelfile string) (*Chain, error) {
+	data, err := os.ReadFile(modelfile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(binaryMagic)+1+4 {
+		return nil, fmt.Errorf("binary model %q is too short", modelfile)
+	}
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("binary model %q has a bad magic number", modelfile)
+	}
+	data = data[len(binaryMagic):]
+
+	gzipped := data[0] == 1
+	data = data[1:]
+
+	body := data[:len(data)-4]
+	wantSum := binary.BigEndian.Uint32(data[len(data)-4:])
+	if gotSum := crc32.ChecksumIEEE(body); gotSum != wantSum {
+		return nil, fmt.Errorf("binary model %q failed its checksum: file is corrupt", modelfile)
+	}
+
+	if gzipped {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		if body, err = io.ReadAll(gz); err != nil {
+			return nil, err
+		}
+	}
+
+	r := bytes.NewReader(body)
+
+	var version uint16
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != binaryFormatVersion {
+		return nil, fmt.Errorf("binary model %q has unsupported version %d", modelfile, version)
+	}
+
+	prefixLenByte, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	prefixLen := int(prefixLenByte)
+
+	numTokens, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]string, numTokens)
+	for i := range tokens {
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		tokens[i] = string(buf)
+	}
+
+	c := NewChain(prefixLen)
+
+	numRecords, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numRecords; i++ {
+		words := make([]string, prefixLen)
+		for j := 0; j < prefixLen; j++ {
+			id, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if id >= uint64(len(tokens)) {
+				return nil, fmt.Errorf("binary model %q references an unknown token id", modelfile)
+			}
+			words[j] = tokens[id]
+		}
+		prefix := strings.Join(words, " ")
+		c.prefixStorage = append(c.prefixStorage, prefix)
+		if c.pairmap[prefix] == nil {
+			c.pairmap[prefix] = make(map[string]int)
+		}
+
+		numSuffixes, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		for k := uint64(0); k < numSuffixes; k++ {
+			id, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			freq, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if id >= uint64(len(tokens)) {
+				return nil, fmt.Errorf("binary model %q references an unknown token id", modelfile)
+			}
+			c.pairmap[prefix][tokens[id]] += int(freq)
+		}
+	}
+
+	return c, nil
+}
+
+// ensureAliasTables lazily builds the per-prefix alias-method samplers from
+// c.pairmap. It's cheap to call repeatedly: once built, the tables are
+// reused until the next Build/BuildReaders call invalidates them.
+func (c *Chain) ensureAliasTables() {
+	c.mu.RLock()
+	ready := c.aliasTables != nil
+	c.mu.RUnlock()
+	if ready {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aliasTables != nil { // another goroutine built it while we waited for the write lock
+		return
+	}
+	c.aliasTables = make(map[string]*aliasTable, len(c.pairmap))
+	for prefix, choices := range c.pairmap {
+		if len(choices) == 0 {
+			continue
+		}
+		c.aliasTables[prefix] = newAliasTable(choices)
+	}
 }
 
 // Generate returns a string of at most n words generated from Chain.
-// modify: read from the modelfreq table you generate in Build()
-// modify: output to use the frequencies you've stored.
 func (c *Chain) Generate(n int) string {
+	c.ensureAliasTables()
 
-	randIndex := rand.Intn(len(c.prefixStorage)) // choose random index to start from
+	c.mu.RLock()
+	if len(c.prefixStorage) == 0 { // nothing built or read yet
+		c.mu.RUnlock()
+		return ""
+	}
+	randIndex := c.rng.Intn(len(c.prefixStorage)) // choose random index to start from
+	startPrefix := c.prefixStorage[randIndex]
+	c.mu.RUnlock()
 
 	p := make(Prefix, c.prefixLen)
-	p = strings.Split(c.prefixStorage[randIndex], " ") // converts the string key stored in c.pairmap into a prefix
-	var words []string                                 // holds output
+	p = strings.Split(startPrefix, " ") // converts the string key stored in c.pairmap into a prefix
+	var words []string                  // holds output
 
 	words = append(words, p.String())
 	for i := 0; i < n-c.prefixLen; i++ {
-		choices := c.pairmap[p.String()] //returns a map
-		if len(choices) == 0 {           //at end of chain
+		c.mu.RLock()
+		table := c.aliasTables[p.String()]
+		c.mu.RUnlock()
+		if table == nil { //at end of chain
 			break
 		}
 
-		keys := make([]string, 0, len(choices)) // this list will hold the suffix strings w/ their relative frequencies
-		for key := range choices {              // for each suffix string
-			for j := 0; j < choices[key]; j++ { //for each time we saw the suffix
-				// append the key once for each time it was recorded in the freqmap
-				// for example: suffix["how":4, "can": 2] gets recorded in keys[] as -> [how, how, how, how, can, can]
-				keys = append(keys, key)
+		suffix := table.sample(c.rng)
 
-			}
+		words = append(words, suffix)
+		p.Shift(suffix)
+
+	}
+	return strings.Join(words, " ")
+}
+
+// GenerateSentence returns at most maxWords words starting from a
+// _BEGIN_-anchored prefix and stopping as soon as endToken is drawn,
+// instead of truncating mid-sentence at a fixed word count. It requires
+// the chain to have been built with EnableSentenceMode.
+func (c *Chain) GenerateSentence(maxWords int) string {
+	c.ensureAliasTables()
+
+	p := make(Prefix, c.prefixLen)
+	for i := range p {
+		p[i] = beginToken
+	}
+
+	words := make([]string, 0, maxWords)
+	for i := 0; i < maxWords; i++ {
+		c.mu.RLock()
+		table := c.aliasTables[p.String()]
+		c.mu.RUnlock()
+		if table == nil { //at end of chain
+			break
+		}
+
+		suffix := table.sample(c.rng)
+		if suffix == endToken {
+			break
 		}
-		// choose a random suffix within keys[]
-		var suffix string
-		suffix = keys[rand.Intn(len(keys))]
 
 		words = append(words, suffix)
 		p.Shift(suffix)
-
 	}
 	return strings.Join(words, " ")
 }
 
+// interactiveTopN is the number of candidate suffixes shown as hints in
+// interactive mode.
+const interactiveTopN = 5
+
+// suffixHint is one candidate suffix shown to the user in interactive mode.
+type suffixHint struct {
+	word string
+	freq int
+}
+
+// topSuffixes returns up to n suffixes for choices, ranked by descending
+// frequency (ties broken alphabetically for stable output).
+func topSuffixes(choices map[string]int, n int) []suffixHint {
+	hints := make([]suffixHint, 0, len(choices))
+	for w, f := range choices {
+		hints = append(hints, suffixHint{w, f})
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		if hints[i].freq != hints[j].freq {
+			return hints[i].freq > hints[j].freq
+		}
+		return hints[i].word < hints[j].word
+	})
+	if len(hints) > n {
+		hints = hints[:n]
+	}
+	return hints
+}
+
+// runInteractive repeatedly prompts the user for a seed prefix, then walks
+// the chain one word at a time: it shows the top candidate suffixes with
+// their frequencies, samples one, and lets the user accept it or pick a
+// different candidate instead.
+func runInteractive(c *Chain) {
+	stdin := bufio.NewReader(os.Stdin)
+	fmt.Printf("interactive mode: enter a %d-word seed prefix (blank line to quit)\n", c.prefixLen)
+
+	for {
+		fmt.Print("seed> ")
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return
+		}
+
+		seed := strings.Fields(line)
+		if len(seed) != c.prefixLen {
+			fmt.Printf("expected %d words, got %d\n", c.prefixLen, len(seed))
+			continue
+		}
+
+		p := Prefix(append([]string(nil), seed...))
+		words := append([]string(nil), seed...)
+
+		for {
+			choices := c.pairmap[p.String()]
+			if len(choices) == 0 {
+				fmt.Println("(no known suffixes for this prefix)")
+				break
+			}
+
+			hints := topSuffixes(choices, interactiveTopN)
+			for i, hint := range hints {
+				fmt.Printf("  %d) %s (seen %d times)\n", i+1, hint.word, hint.freq)
+			}
+
+			c.ensureAliasTables()
+			sampled := c.aliasTables[p.String()].sample(c.rng)
+			fmt.Printf("sampled suffix: %q -- press enter to accept, a number to pick one above, or q to stop\n", sampled)
+
+			choice, err := stdin.ReadString('\n')
+			if err != nil {
+				break
+			}
+			choice = strings.TrimSpace(choice)
+
+			if choice == "q" {
+				break
+			}
+
+			next := sampled
+			if idx, convErr := strconv.Atoi(choice); convErr == nil && idx >= 1 && idx <= len(hints) {
+				next = hints[idx-1].word
+			}
+
+			words = append(words, next)
+			p.Shift(next)
+		}
+
+		fmt.Println(strings.Join(words, " "))
+	}
+}
+
 // ReadChainFromFile takes in a filename from user and generates a frequency table, stored in c.pairmap[]
 func ReadChainFromFile(modelfile string) *Chain {
 
@@ -279,13 +959,230 @@ func ReadChainFromFile(modelfile string) *Chain {
 	return c
 }
 
-// run: ./mark read N outfilename infile1 infile2... (N: order of the chain, int. any number of input files)
+// saDelimiter marks the start of each encoded token in a SuffixArrayChain's
+// byte stream, so a k-token context can be turned into a byte pattern and
+// handed to index/suffixarray.Index.Lookup.
+const saDelimiter byte = 0x00
+
+// saBackoffThreshold (tau) is the minimum number of corpus occurrences a
+// context must have before SuffixArrayChain trusts its suffix distribution;
+// below that it backs off to a shorter context.
+const saBackoffThreshold = 3
+
+// SuffixArrayChain is a variable-order Markov model: instead of a fixed
+// prefixLen, it indexes the whole tokenized corpus once with
+// index/suffixarray and, at generation time, looks up counts for contexts
+// of any length up to maxOrder. When a long context is too rare to trust
+// it backs off to a shorter one (Katz-style backoff), all the way down to
+// the corpus-wide unigram distribution if nothing else matches.
+type SuffixArrayChain struct {
+	maxOrder    int
+	vocab       map[string]int // token -> id
+	tokens      []string       // id -> token
+	data        []byte         // delimiter + varint(id) for every token, in corpus order
+	index       *suffixarray.Index
+	unigramFreq map[int]int // id -> corpus-wide occurrence count, the k=0 fallback
+	rng         *rand.Rand
+}
+
+// NewSuffixArrayChain returns a SuffixArrayChain that backs off from
+// contexts of up to maxOrder tokens.
+func NewSuffixArrayChain(maxOrder int) *SuffixArrayChain {
+	return &SuffixArrayChain{
+		maxOrder:    maxOrder,
+		vocab:       make(map[string]int),
+		tokens:      make([]string, 0),
+		unigramFreq: make(map[int]int),
+		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetSeed reseeds the chain's PRNG, for deterministic Generate output.
+func (sc *SuffixArrayChain) SetSeed(seed int64) {
+	sc.rng = rand.New(rand.NewSource(seed))
+}
+
+// internToken returns tok's id, assigning it the next free id the first
+// time tok is seen.
+func (sc *SuffixArrayChain) internToken(tok string) int {
+	if id, ok := sc.vocab[tok]; ok {
+		return id
+	}
+	id := len(sc.tokens)
+	sc.vocab[tok] = id
+	sc.tokens = append(sc.tokens, tok)
+	return id
+}
+
+// encodeSAToken appends id to buf as saDelimiter followed by its varint. Ids
+// are encoded as id+1: a bare varint(0) is the single byte 0x00, which
+// would be indistinguishable from saDelimiter itself and break pattern
+// alignment for token id 0; no other id can ever encode to a 0x00 byte; see
+// https://pkg.go.dev/encoding/binary#PutUvarint (continuation bytes always
+// have their high bit set, and the final byte of a varint is only zero for
+// the value 0).
+func encodeSAToken(buf *bytes.Buffer, id int) {
+	buf.WriteByte(saDelimiter)
+	writeUvarint(buf, uint64(id)+1)
+}
+
+// Build tokenizes r and folds it into the indexed corpus. Build may be
+// called more than once (once per input file, say); each call re-indexes
+// the whole accumulated token stream with index/suffixarray, since the
+// package has no incremental-update API.
+func (sc *SuffixArrayChain) Build(r io.Reader) {
+	br := bufio.NewReader(r)
+	buf := bytes.NewBuffer(sc.data)
+	for {
+		var s string
+		if _, err := fmt.Fscan(br, &s); err != nil {
+			break
+		}
+		id := sc.internToken(s)
+		sc.unigramFreq[id]++
+		encodeSAToken(buf, id)
+	}
+	sc.data = buf.Bytes()
+	sc.index = suffixarray.New(sc.data)
+}
+
+// encodeSAContext builds the byte pattern for a context of token ids, in the
+// same encoding Build used, so it can be looked up in sc.index.
+func encodeSAContext(ids []int) []byte {
+	var buf bytes.Buffer
+	for _, id := range ids {
+		encodeSAToken(&buf, id)
+	}
+	return buf.Bytes()
+}
+
+// nextTokenCounts tallies, over every occurrence of pattern in the corpus,
+// the token id that immediately follows it.
+func (sc *SuffixArrayChain) nextTokenCounts(pattern []byte) map[int]int {
+	counts := make(map[int]int)
+	for _, off := range sc.index.Lookup(pattern, -1) {
+		pos := off + len(pattern)
+		if pos >= len(sc.data) || sc.data[pos] != saDelimiter {
+			continue
+		}
+		id, n := binary.Uvarint(sc.data[pos+1:])
+		if n <= 0 || id == 0 {
+			continue
+		}
+		counts[int(id)-1]++
+	}
+	return counts
+}
+
+// sampleNext walks the backoff chain from len(context) tokens of history
+// down to the unigram distribution, stopping at the first order with at
+// least saBackoffThreshold occurrences to sample from.
+func (sc *SuffixArrayChain) sampleNext(context []int) (int, bool) {
+	for k := len(context); k > 0; k-- {
+		counts := sc.nextTokenCounts(encodeSAContext(context[len(context)-k:]))
+		if sumCounts(counts) >= saBackoffThreshold {
+			return sampleWeighted(sc.rng, counts), true
+		}
+	}
+	if len(sc.unigramFreq) == 0 {
+		return 0, false
+	}
+	return sampleWeighted(sc.rng, sc.unigramFreq), true
+}
+
+// Generate returns a string of n tokens sampled via Katz-style backoff.
+func (sc *SuffixArrayChain) Generate(n int) string {
+	if len(sc.tokens) == 0 {
+		return ""
+	}
+
+	context := make([]int, 0, sc.maxOrder)
+	words := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		id, ok := sc.sampleNext(context)
+		if !ok {
+			break
+		}
+		words = append(words, sc.tokens[id])
+
+		context = append(context, id)
+		if len(context) > sc.maxOrder {
+			context = context[len(context)-sc.maxOrder:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// sumCounts totals the occurrence counts in a nextTokenCounts result.
+func sumCounts(counts map[int]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// sampleWeighted draws a key from counts proportional to its value. It's a
+// simple cumulative-frequency draw rather than an aliasTable, since each
+// counts map it gets is built fresh for one generated token and sampled
+// from only once.
+func sampleWeighted(rng *rand.Rand, counts map[int]int) int {
+	total := sumCounts(counts)
+	if total == 0 {
+		return 0
+	}
+
+	ids := make([]int, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids) // deterministic iteration order for a given seed
+
+	draw := rng.Intn(total)
+	for _, id := range ids {
+		draw -= counts[id]
+		if draw < 0 {
+			return id
+		}
+	}
+	return ids[len(ids)-1]
+}
+
+// loadChainFromFile reads a saved model, picking the binary or text reader
+// by sniffing the file's magic rather than trusting a flag, since that's
+// what generate/interactive need to work with output from either format.
+func loadChainFromFile(modelfile string) *Chain {
+	binaryFormat, err := isBinaryModel(modelfile)
+	if err != nil {
+		panic("error opening file")
+	}
+	if binaryFormat {
+		c, err := ReadBinaryChainFromFile(modelfile)
+		if err != nil {
+			panic("error reading binary model: " + err.Error())
+		}
+		return c
+	}
+	return ReadChainFromFile(modelfile)
+}
+
+// run: ./mark read N outfilename infile1 infile2... [-sentence] [--format=text|binary] [--gzip]
+// (N: order of the chain, int. any number of input files)
 // read each inputfile, make one collective freq table, sort the freq table, save freq table to outile
+// -sentence segments each file into sentences and bounds them with _BEGIN_/_END_ tokens
+// --format selects the modelfile format written (default text); --gzip only applies to binary
 // run: ./mark generate modelfile N (modelfile: name of saved file from READ, n: words to output, int.)
-// read freq table in modelfile, use it to generate n words of output
+// read freq table in modelfile (text or binary, auto-detected), use it to generate n words of output
+// run: ./mark interactive modelfile (modelfile: name of saved file from READ)
+// walk the chain one word at a time, showing candidate suffixes and letting the user pick
+// run: ./mark gensentence modelfile maxWords (modelfile: saved file from a READ -sentence build)
+// generate at most maxWords words, stopping at the first sentence boundary instead of truncating mid-sentence
+// run: ./mark backoff maxOrder N infile1 infile2... (maxOrder: longest context tried before backing off, N: words to output)
+// index the inputfiles with a SuffixArrayChain and generate N words directly, no modelfile round-trip
 func main() {
 	// Register command-line flags.
-	rand.Seed(time.Now().UnixNano()) // Seed the random number generator.
+	// Each Chain seeds its own *rand.Rand (see NewChain), so there's no
+	// package-global generator to seed here anymore.
 	//numWords := flag.Int("words", 100, "maximum number of words to print")
 	//prefixLen := flag.Int("prefix", 2, "prefix length in words")
 
@@ -300,21 +1197,54 @@ func main() {
 		}
 		c := NewChain(order) // Initialize a new Chain, markov length order
 		files := os.Args[4:] //filenames start at 4
-		for index := range files {
-			var collection io.Reader                  //initialize io.Reader
-			fmt.Println("reading file", files[index]) //debug
-			f, err := os.Open(files[index])
+
+		sentenceMode := false
+		binaryFormat := false
+		useGzip := false
+		remaining := make([]string, 0, len(files))
+		for _, f := range files { // pull flags out of the file list, wherever they land
+			switch {
+			case f == "-sentence" || f == "--sentence":
+				sentenceMode = true
+			case f == "--format=binary":
+				binaryFormat = true
+			case f == "--format=text":
+				binaryFormat = false
+			case f == "--gzip":
+				useGzip = true
+			default:
+				remaining = append(remaining, f)
+			}
+		}
+		files = remaining
+		if sentenceMode {
+			c.EnableSentenceMode(nil)
+		}
+
+		readers := make([]io.Reader, 0, len(files))
+		for _, name := range files {
+			fmt.Println("reading file", name) //debug
+			f, err := os.Open(name)
 			if err != nil {
 				panic("trouble opening file")
 			}
 			defer f.Close()
-			collection = f
-			c.Build(collection) // new READ: Build chains/freqmap from file
+			readers = append(readers, f)
+		}
 
+		// one goroutine per file, bounded to 4 at a time, merged into c under its lock
+		if err := c.BuildReaders(context.Background(), readers, 4); err != nil {
+			panic("trouble building chain from input files: " + err.Error())
 		}
 
 		// if gotten here: should have built the chain + freqmap in chain struct. Now write to outputfile
-		c.WriteModel(outputFile)
+		if binaryFormat {
+			if err := c.WriteBinaryModel(outputFile, useGzip); err != nil {
+				panic("trouble writing binary model: " + err.Error())
+			}
+		} else {
+			c.WriteModel(outputFile)
+		}
 
 	} //end READ
 
@@ -325,10 +1255,54 @@ func main() {
 			panic("trouble parsing integer numWords from command line")
 		}
 
-		c := ReadChainFromFile(modelfile)
+		c := loadChainFromFile(modelfile)
 		text := c.Generate(numWords)
 		fmt.Println(text)
 
 	}
 
+	if os.Args[1] == "interactive" || os.Args[1] == "INTERACTIVE" {
+		modelfile := os.Args[2]
+		c := loadChainFromFile(modelfile)
+		runInteractive(c)
+	}
+
+	if os.Args[1] == "gensentence" || os.Args[1] == "GENSENTENCE" {
+		modelfile := os.Args[2]
+		maxWords, err := strconv.Atoi(os.Args[3])
+		if err != nil {
+			panic("trouble parsing integer maxWords from command line")
+		}
+
+		c := loadChainFromFile(modelfile)
+		text := c.GenerateSentence(maxWords)
+		fmt.Println(text)
+	}
+
+	if os.Args[1] == "backoff" || os.Args[1] == "BACKOFF" {
+		maxOrder, err := strconv.Atoi(os.Args[2])
+		if err != nil {
+			panic("trouble parsing maxOrder")
+		}
+		numWords, err := strconv.Atoi(os.Args[3])
+		if err != nil {
+			panic("trouble parsing integer numWords from command line")
+		}
+
+		readers := make([]io.Reader, 0, len(os.Args[4:]))
+		for _, name := range os.Args[4:] {
+			fmt.Println("reading file", name) //debug
+			f, err := os.Open(name)
+			if err != nil {
+				panic("trouble opening file")
+			}
+			defer f.Close()
+			readers = append(readers, f)
+		}
+
+		sc := NewSuffixArrayChain(maxOrder)
+		sc.Build(io.MultiReader(readers...)) // one Build call so the suffix array is indexed only once
+		fmt.Println(sc.Generate(numWords))
+	}
+
 } // end main